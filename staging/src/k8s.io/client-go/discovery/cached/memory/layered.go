@@ -0,0 +1,404 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+)
+
+// StaleCacheError wraps a live-fetch failure that was masked by serving a
+// disk-cached entry instead, so callers that care can tell the difference
+// between "this is current" and "this is the best we had offline".
+type StaleCacheError struct {
+	// Err is the error the live delegate returned.
+	Err error
+}
+
+func (e *StaleCacheError) Error() string {
+	return fmt.Sprintf("serving stale disk-cached discovery data after a live fetch error: %v", e.Err)
+}
+
+func (e *StaleCacheError) Unwrap() error {
+	return e.Err
+}
+
+// LayeredOptions configures NewLayeredCacheClient.
+type LayeredOptions struct {
+	// ServeStaleOnError, when true, makes a live-fetch error fall back to
+	// the on-disk copy (wrapped in a *StaleCacheError) instead of
+	// propagating the error to the caller. When false (the default), disk
+	// is only ever used to warm the in-memory cache on construction.
+	//
+	// The stale copy is only returned for per-groupVersion resource lists.
+	// A failed group list fetch returns the *StaleCacheError with no group
+	// list, since memCacheClient does not keep a group list that comes with
+	// an error.
+	ServeStaleOnError bool
+}
+
+// diskCacheEntry is the on-disk representation of a cached groupVersion's
+// resource list, written to diskDir/<host>/<groupVersion>.json.
+type diskCacheEntry struct {
+	ResourceList *metav1.APIResourceList `json:"resourceList,omitempty"`
+	Err          string                  `json:"err,omitempty"`
+}
+
+// diskGroupListEntry is the on-disk representation of the cached group list,
+// written to diskDir/<host>/servergroups.json.
+type diskGroupListEntry struct {
+	GroupList *metav1.APIGroupList `json:"groupList,omitempty"`
+	Err       string               `json:"err,omitempty"`
+}
+
+const groupListFileName = "servergroups.json"
+
+// layeredCacheClient composes the existing in-memory cache with a
+// disk-backed tier, so a fresh process can serve cached discovery
+// immediately on a cold start instead of blocking on (or failing due to) the
+// apiserver being briefly unreachable.
+type layeredCacheClient struct {
+	discovery.CachedDiscoveryInterface
+
+	delegate discovery.DiscoveryInterface
+	dir      string
+	opts     LayeredOptions
+
+	lock sync.Mutex
+}
+
+// NewLayeredCacheClient wraps delegate with the standard in-memory cache and
+// a disk-backed fallback tier rooted at diskDir. On construction, any
+// previously written disk entries are loaded into the in-memory cache so the
+// first call can be served without hitting the apiserver. Successful live
+// fetches are written back to disk asynchronously (fsync + rename, so a
+// crash mid-write never leaves a corrupted file for the next process to
+// trip over); a corrupted file is treated as a cache miss, not an error.
+//
+// A delegate with a REST client is first sent memCacheClient's aggregated
+// discovery request whenever the cache refreshes. When the apiserver answers
+// it, the cache is filled from that one response, which is neither written to
+// disk nor served from it: the disk tier is only read and written on the
+// per-groupVersion path.
+func NewLayeredCacheClient(delegate discovery.DiscoveryInterface, diskDir string, opts LayeredOptions) discovery.CachedDiscoveryInterface {
+	l := &layeredCacheClient{
+		delegate: delegate,
+		dir:      diskDir,
+		opts:     opts,
+	}
+	inner := &layeredDelegate{DiscoveryInterface: delegate, layered: l}
+	l.CachedDiscoveryInterface = NewMemCacheClient(inner)
+	l.warmFromDisk(inner)
+	return l
+}
+
+// warmFromDisk loads every well-formed entry under l.dir into the
+// just-constructed in-memory cache, so Fresh()-gated callers can get a
+// cold-start answer without a network round trip. Corrupted or missing files
+// are silently skipped; the client falls through to the network for them.
+func (l *layeredCacheClient) warmFromDisk(inner *layeredDelegate) {
+	host := l.hostDir()
+	entries, err := os.ReadDir(host)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		if entry.Name() == groupListFileName {
+			if _, ok := l.readDiskGroupList(); ok {
+				inner.markGroupsWarm()
+			}
+			continue
+		}
+		groupVersion := decodeGroupVersionFileName(entry.Name())
+		if groupVersion == "" {
+			continue
+		}
+		if _, _, ok := l.readDiskEntry(groupVersion); ok {
+			inner.markWarm(groupVersion)
+		}
+	}
+}
+
+// defaultHostDir names the bucket used when the delegate's RESTClient()
+// can't tell us a host at all (most commonly a minimal test fake with no
+// real HTTP plumbing). Real delegates always resolve to their own
+// restHost(), so two clusters sharing a diskDir never collide on this.
+const defaultHostDir = "default"
+
+// hostDir returns the per-cluster bucket under l.dir that this client's
+// entries live in, so one diskDir can safely back caches for multiple
+// clusters/apiservers: diskDir/<host>/<groupVersion>.json.
+func (l *layeredCacheClient) hostDir() string {
+	return filepath.Join(l.dir, url.QueryEscape(l.restHost()))
+}
+
+// restHost derives a stable bucket name from the delegate's own REST client
+// config, without issuing a request: Request.URL() just assembles the
+// target URL locally from the client's base configuration.
+func (l *layeredCacheClient) restHost() string {
+	restClient := l.delegate.RESTClient()
+	if restClient == nil {
+		return defaultHostDir
+	}
+	u := restClient.Get().URL()
+	if u == nil || u.Host == "" {
+		return defaultHostDir
+	}
+	return u.Host
+}
+
+func (l *layeredCacheClient) diskPath(groupVersion string) string {
+	return filepath.Join(l.hostDir(), encodeGroupVersionFileName(groupVersion)+".json")
+}
+
+// readDiskEntry reads and decodes the on-disk entry for groupVersion. A
+// missing or corrupted file is reported as ok=false so callers fall through
+// to the network, never as an error.
+func (l *layeredCacheClient) readDiskEntry(groupVersion string) (*metav1.APIResourceList, error, bool) {
+	raw, err := os.ReadFile(l.diskPath(groupVersion))
+	if err != nil {
+		return nil, nil, false
+	}
+	var entry diskCacheEntry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return nil, nil, false
+	}
+	if entry.Err != "" {
+		return nil, fmt.Errorf("%s", entry.Err), true
+	}
+	return entry.ResourceList, nil, true
+}
+
+// writeDiskEntry persists a successful fetch for groupVersion via
+// write-to-temp-then-rename, so a process that crashes mid-write never
+// leaves a half-written file for the next process to choke on.
+func (l *layeredCacheClient) writeDiskEntry(groupVersion string, resourceList *metav1.APIResourceList, fetchErr error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	host := l.hostDir()
+	if err := os.MkdirAll(host, 0o755); err != nil {
+		return
+	}
+
+	entry := diskCacheEntry{ResourceList: resourceList}
+	if fetchErr != nil {
+		entry.Err = fetchErr.Error()
+	}
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	dest := l.diskPath(groupVersion)
+	tmp, err := os.CreateTemp(host, ".tmp-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		return
+	}
+	_ = os.Rename(tmp.Name(), dest)
+}
+
+func (l *layeredCacheClient) groupListPath() string {
+	return filepath.Join(l.hostDir(), groupListFileName)
+}
+
+// readDiskGroupList mirrors readDiskEntry for the cached group list.
+func (l *layeredCacheClient) readDiskGroupList() (*metav1.APIGroupList, bool) {
+	raw, err := os.ReadFile(l.groupListPath())
+	if err != nil {
+		return nil, false
+	}
+	var entry diskGroupListEntry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return nil, false
+	}
+	if entry.Err != "" || entry.GroupList == nil {
+		return nil, false
+	}
+	return entry.GroupList, true
+}
+
+// writeDiskGroupList mirrors writeDiskEntry for the cached group list.
+func (l *layeredCacheClient) writeDiskGroupList(groupList *metav1.APIGroupList) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	host := l.hostDir()
+	if err := os.MkdirAll(host, 0o755); err != nil {
+		return
+	}
+	raw, err := json.Marshal(diskGroupListEntry{GroupList: groupList})
+	if err != nil {
+		return
+	}
+
+	tmp, err := os.CreateTemp(host, ".tmp-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		return
+	}
+	_ = os.Rename(tmp.Name(), l.groupListPath())
+}
+
+// encodeGroupVersionFileName/decodeGroupVersionFileName turn a groupVersion
+// like "apps/v1" into a safe single path segment and back, since
+// groupVersion may contain a slash.
+func encodeGroupVersionFileName(groupVersion string) string {
+	return url.QueryEscape(groupVersion)
+}
+
+func decodeGroupVersionFileName(name string) string {
+	base := name[:len(name)-len(filepath.Ext(name))]
+	gv, err := url.QueryUnescape(base)
+	if err != nil {
+		return ""
+	}
+	return gv
+}
+
+// layeredDelegate sits between memCacheClient and the real delegate: it
+// serves a disk-warmed entry exactly once per groupVersion (to seed the
+// in-memory cache on cold start) and otherwise does a live fetch, writing
+// the result back to disk and optionally falling back to the disk copy on
+// error.
+type layeredDelegate struct {
+	// delegate is embedded so every DiscoveryInterface method layeredDelegate
+	// doesn't override (RESTClient, ServerVersion, OpenAPISchema, OpenAPIV3,
+	// ServerPreferredResources, ServerPreferredNamespacedResources) forwards
+	// straight to it. An embedded-but-unassigned interface field would
+	// satisfy the type check and then panic on the first such call, since
+	// there'd be no concrete value to dispatch to.
+	discovery.DiscoveryInterface
+
+	layered *layeredCacheClient
+
+	lock       sync.Mutex
+	warm       map[string]bool
+	groupsWarm bool
+}
+
+func (d *layeredDelegate) markGroupsWarm() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.groupsWarm = true
+}
+
+func (d *layeredDelegate) takeGroupsWarm() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.groupsWarm {
+		d.groupsWarm = false
+		return true
+	}
+	return false
+}
+
+func (d *layeredDelegate) ServerGroups() (*metav1.APIGroupList, error) {
+	if d.takeGroupsWarm() {
+		if groupList, ok := d.layered.readDiskGroupList(); ok {
+			return groupList, nil
+		}
+	}
+
+	groupList, err := d.DiscoveryInterface.ServerGroups()
+	if err != nil {
+		if d.layered.opts.ServeStaleOnError {
+			if staleList, ok := d.layered.readDiskGroupList(); ok {
+				return staleList, &StaleCacheError{Err: err}
+			}
+		}
+		return nil, err
+	}
+
+	go d.layered.writeDiskGroupList(groupList)
+	return groupList, nil
+}
+
+func (d *layeredDelegate) markWarm(groupVersion string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.warm == nil {
+		d.warm = map[string]bool{}
+	}
+	d.warm[groupVersion] = true
+}
+
+func (d *layeredDelegate) takeWarm(groupVersion string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.warm != nil && d.warm[groupVersion] {
+		delete(d.warm, groupVersion)
+		return true
+	}
+	return false
+}
+
+func (d *layeredDelegate) ServerResourcesForGroupVersion(groupVersion string) (*metav1.APIResourceList, error) {
+	if d.takeWarm(groupVersion) {
+		if list, err, ok := d.layered.readDiskEntry(groupVersion); ok {
+			return list, err
+		}
+	}
+
+	list, err := d.DiscoveryInterface.ServerResourcesForGroupVersion(groupVersion)
+	if err != nil {
+		if d.layered.opts.ServeStaleOnError {
+			if staleList, staleErr, ok := d.layered.readDiskEntry(groupVersion); ok && staleErr == nil {
+				return staleList, &StaleCacheError{Err: err}
+			}
+		}
+		return nil, err
+	}
+
+	go d.layered.writeDiskEntry(groupVersion, list, nil)
+	return list, nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+func astronomyFakeWithErr(err error) *fakeDiscovery {
+	return &fakeDiscovery{
+		groupList: &metav1.APIGroupList{
+			Groups: []metav1.APIGroup{{
+				Name: "astronomy",
+				Versions: []metav1.GroupVersionForDiscovery{{
+					GroupVersion: "astronomy/v8beta1",
+					Version:      "v8beta1",
+				}},
+			}},
+		},
+		resourceMap: map[string]*resourceMapEntry{
+			"astronomy/v8beta1": {
+				list: &metav1.APIResourceList{GroupVersion: "astronomy/v8beta1"},
+				err:  err,
+			},
+		},
+	}
+}
+
+func waitForDiskEntry(t *testing.T, path string) {
+	t.Helper()
+	deadline := time.After(2 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("timed out waiting for %s to be written", path)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestLayeredCacheColdStart(t *testing.T) {
+	dir := t.TempDir()
+
+	firstProcessFake := astronomyFakeWithErr(nil)
+	first := NewLayeredCacheClient(firstProcessFake, dir, LayeredOptions{})
+	if _, err := first.ServerResourcesForGroupVersion("astronomy/v8beta1"); err != nil {
+		t.Fatalf("unexpected error priming the disk cache: %v", err)
+	}
+	waitForDiskEntry(t, filepath.Join(dir, "default", encodeGroupVersionFileName("astronomy/v8beta1")+".json"))
+	waitForDiskEntry(t, filepath.Join(dir, "default", groupListFileName))
+
+	secondProcessFake := astronomyFakeWithErr(errors.New("the apiserver is unreachable on cold start"))
+	secondProcessFake.groupListErr = errors.New("the apiserver is unreachable on cold start")
+
+	second := NewLayeredCacheClient(secondProcessFake, dir, LayeredOptions{})
+	r, err := second.ServerResourcesForGroupVersion("astronomy/v8beta1")
+	if err != nil {
+		t.Fatalf("expected the disk-warmed entry to serve without hitting the network, got error: %v", err)
+	}
+	if r.GroupVersion != "astronomy/v8beta1" {
+		t.Errorf("unexpected disk-warmed resource list: %#v", r)
+	}
+}
+
+func TestLayeredCacheCorruptedFileFallsThroughToNetwork(t *testing.T) {
+	dir := t.TempDir()
+	host := filepath.Join(dir, "default")
+	if err := os.MkdirAll(host, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	corruptPath := filepath.Join(host, encodeGroupVersionFileName("astronomy/v8beta1")+".json")
+	if err := os.WriteFile(corruptPath, []byte("{not valid json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := astronomyFakeWithErr(nil)
+	c := NewLayeredCacheClient(fake, dir, LayeredOptions{})
+	r, err := c.ServerResourcesForGroupVersion("astronomy/v8beta1")
+	if err != nil {
+		t.Fatalf("unexpected error falling through to the network: %v", err)
+	}
+	if r.GroupVersion != "astronomy/v8beta1" {
+		t.Errorf("unexpected resource list from network fallback: %#v", r)
+	}
+}
+
+func TestLayeredCacheServesStaleOnError(t *testing.T) {
+	dir := t.TempDir()
+
+	onlineFake := astronomyFakeWithErr(nil)
+	online := NewLayeredCacheClient(onlineFake, dir, LayeredOptions{ServeStaleOnError: true})
+	if _, err := online.ServerResourcesForGroupVersion("astronomy/v8beta1"); err != nil {
+		t.Fatalf("unexpected error priming the disk cache: %v", err)
+	}
+	waitForDiskEntry(t, filepath.Join(dir, "default", encodeGroupVersionFileName("astronomy/v8beta1")+".json"))
+	waitForDiskEntry(t, filepath.Join(dir, "default", groupListFileName))
+
+	offlineFake := astronomyFakeWithErr(errors.New("network unreachable"))
+	offline := NewLayeredCacheClient(offlineFake, dir, LayeredOptions{ServeStaleOnError: true})
+	// Consume the disk-warmed entry once so the next call goes to the
+	// (failing) network, exercising the error-path fallback rather than the
+	// cold-start warm path.
+	if _, err := offline.ServerResourcesForGroupVersion("astronomy/v8beta1"); err != nil {
+		t.Fatalf("unexpected error serving the disk-warmed entry: %v", err)
+	}
+	offline.Invalidate()
+	r, err := offline.ServerResourcesForGroupVersion("astronomy/v8beta1")
+	if r == nil || r.GroupVersion != "astronomy/v8beta1" {
+		t.Fatalf("expected the stale disk copy to be served, got %#v, err=%v", r, err)
+	}
+	var staleErr *StaleCacheError
+	if !errors.As(err, &staleErr) {
+		t.Errorf("expected a *StaleCacheError wrapping the live-fetch failure, got %v", err)
+	}
+}
+
+// TestLayeredCacheHostDirPerCluster guards against two clusters sharing a
+// diskDir silently reading and overwriting each other's cache files: each
+// delegate's own REST host must bucket into a distinct directory.
+func TestLayeredCacheHostDirPerCluster(t *testing.T) {
+	dir := t.TempDir()
+
+	a := NewLayeredCacheClient(
+		discovery.NewDiscoveryClientForConfigOrDie(&rest.Config{Host: "https://cluster-a.example:6443"}),
+		dir, LayeredOptions{},
+	).(*layeredCacheClient)
+	b := NewLayeredCacheClient(
+		discovery.NewDiscoveryClientForConfigOrDie(&rest.Config{Host: "https://cluster-b.example:6443"}),
+		dir, LayeredOptions{},
+	).(*layeredCacheClient)
+
+	if a.hostDir() == b.hostDir() {
+		t.Fatalf("expected distinct per-cluster buckets, both resolved to %s", a.hostDir())
+	}
+	if a.hostDir() == filepath.Join(dir, defaultHostDir) {
+		t.Errorf("expected a real delegate to resolve to its own host, got the %q fallback", defaultHostDir)
+	}
+}
@@ -0,0 +1,385 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	errorsutil "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/openapi"
+	"k8s.io/client-go/openapi/cached"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/utils/clock"
+)
+
+// RefreshMode controls what a memCacheClient does when a cached entry has
+// passed its TTL but has not yet hit its hard max-age.
+type RefreshMode int
+
+const (
+	// Blocking is the default: a caller that observes a past-TTL entry waits
+	// for a synchronous refresh before getting a result, same as the
+	// long-standing Invalidate()-then-refetch behavior.
+	Blocking RefreshMode = iota
+	// StaleWhileRevalidate returns the cached entry immediately (as long as
+	// it is within the hard max-age) and kicks off a single, deduplicated
+	// background refresh to replace it for the next caller.
+	StaleWhileRevalidate
+)
+
+// Options configures the soft/hard expiry behavior of a memCacheClient
+// created via NewMemCacheClientWithOptions. The zero value reproduces the
+// historical NewMemCacheClient behavior, where nothing expires on its own
+// and only an explicit Invalidate() forces a refresh.
+type Options struct {
+	// TTL is the soft-expiry for a cached entry (both the group list and
+	// individual groupVersion resource lists). Once an entry is older than
+	// TTL, it is refreshed according to RefreshMode. A zero TTL disables
+	// time-based expiry, so entries are only replaced by Invalidate().
+	TTL time.Duration
+	// NegativeTTL bounds how long a permanent (non-retryable) error is
+	// served from cache before it is retried without an explicit
+	// Invalidate(). A zero NegativeTTL falls back to TTL; if both are zero,
+	// permanent errors are cached until the next Invalidate(), matching
+	// historical behavior. Retryable errors are never bound by NegativeTTL:
+	// they are always retried on the next call, as before.
+	NegativeTTL time.Duration
+	// MaxAge is the hard expiry: once an entry is older than MaxAge, it is
+	// never served stale regardless of RefreshMode, and the caller always
+	// waits for a synchronous refresh. A zero MaxAge means StaleWhileRevalidate
+	// can serve arbitrarily stale entries while a refresh races in the
+	// background.
+	MaxAge time.Duration
+	// RefreshMode selects what happens to a past-TTL, pre-MaxAge entry.
+	RefreshMode RefreshMode
+	// Clock is used to timestamp and evaluate cache entries. Defaults to the
+	// real clock; tests inject a fake clock to control TTL expiry
+	// deterministically.
+	Clock clock.Clock
+}
+
+type cacheEntry struct {
+	resourceList *metav1.APIResourceList
+	err          error
+	fetchedAt    time.Time
+}
+
+// memCacheClient can Invalidate() to stay up to date with discovery
+// information.
+//
+// TODO: Switch to a watch interface. Right now it will poll
+// after each Invalidate() call.
+type memCacheClient struct {
+	delegate discovery.DiscoveryInterface
+
+	lock                   sync.Mutex
+	groupToServerResources map[string]*cacheEntry
+	groupList              *metav1.APIGroupList
+	cacheValid             bool
+	groupsFetchedAt        time.Time
+	openapiClient          openapi.Client
+
+	// lastDigest, aggregatedGroups and aggregatedResources are only
+	// populated when the delegate's RESTClient() answers the aggregated
+	// discovery content type (see refreshAggregatedLocked). They survive
+	// Invalidate() (unlike groupList/groupToServerResources) so the next
+	// refresh can skip re-converting the document when lastDigest shows its
+	// content hasn't changed. lastDigest is a digest memCacheClient computes
+	// itself, not an apiserver-issued ETag: discovery.DiscoveryInterface's
+	// RESTClient() doesn't expose response headers, so there is no way to
+	// read the apiserver's real ETag back out through it.
+	lastDigest          string
+	aggregatedGroups    *metav1.APIGroupList
+	aggregatedResources map[string]*metav1.APIResourceList
+
+	opts Options
+	// groupsInFlight (for the group list) and resourcesInFlight (keyed by
+	// groupVersion) dedupe concurrent StaleWhileRevalidate background
+	// refreshes, so a burst of callers past TTL only triggers one refetch.
+	groupsInFlight    bool
+	resourcesInFlight map[string]bool
+
+	// obs receives cache hit/miss/error/invalidate callbacks; defaults to
+	// noopObserver{} so call sites never need a nil check.
+	obs CacheObserver
+}
+
+var _ discovery.CachedDiscoveryInterface = &memCacheClient{}
+
+// NewMemCacheClient creates a new CachedDiscoveryInterface which caches
+// discovery information in memory and will stay up-to-date if Invalidate is
+// called with regularity.
+//
+// A groupVersion that is not cached yet is fetched live on its first lookup.
+func NewMemCacheClient(delegate discovery.DiscoveryInterface) discovery.CachedDiscoveryInterface {
+	return NewMemCacheClientWithOptions(delegate, Options{})
+}
+
+// NewMemCacheClientWithOptions is like NewMemCacheClient but additionally
+// lets cached entries soft- and hard-expire on their own, so long-running
+// controllers are not stuck serving stale discovery for the rest of the
+// process lifetime between explicit Invalidate() calls.
+func NewMemCacheClientWithOptions(delegate discovery.DiscoveryInterface, opts Options) discovery.CachedDiscoveryInterface {
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+	return &memCacheClient{
+		delegate:               delegate,
+		groupToServerResources: map[string]*cacheEntry{},
+		openapiClient:          cached.NewClient(delegate.OpenAPIV3()),
+		opts:                   opts,
+		resourcesInFlight:      map[string]bool{},
+		obs:                    noopObserver{},
+	}
+}
+
+// ServerResourcesForGroupVersion returns the supported resources for a group
+// and version.
+func (d *memCacheClient) ServerResourcesForGroupVersion(groupVersion string) (*metav1.APIResourceList, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if !d.cacheValid {
+		if err := d.refreshLocked(); err != nil {
+			return nil, err
+		}
+	}
+	cachedVal, ok := d.groupToServerResources[groupVersion]
+	if !ok {
+		return d.fetchResourcesLocked(groupVersion)
+	}
+
+	if cachedVal.err != nil {
+		// Retryable errors are never cached for long: retry on every call,
+		// exactly as before NegativeTTL existed.
+		if isTransientError(cachedVal.err) {
+			return d.fetchResourcesLocked(groupVersion)
+		}
+		// Permanent errors are normally only cleared by Invalidate(), but a
+		// configured NegativeTTL (falling back to TTL) lets them expire on
+		// their own so long-running callers aren't stuck forever.
+		negativeTTL := d.opts.NegativeTTL
+		if negativeTTL == 0 {
+			negativeTTL = d.opts.TTL
+		}
+		if negativeTTL != 0 && d.pastLocked(cachedVal.fetchedAt, negativeTTL) {
+			return d.fetchResourcesLocked(groupVersion)
+		}
+		d.obs.OnHit(groupVersion)
+		return cachedVal.resourceList, cachedVal.err
+	}
+
+	if d.opts.TTL != 0 && d.pastLocked(cachedVal.fetchedAt, d.opts.TTL) {
+		if d.opts.RefreshMode == StaleWhileRevalidate && !d.pastMaxAgeLocked(cachedVal.fetchedAt) {
+			d.maybeStartResourceRefreshLocked(groupVersion)
+			d.obs.OnHit(groupVersion)
+			return cachedVal.resourceList, cachedVal.err
+		}
+		return d.fetchResourcesLocked(groupVersion)
+	}
+	d.obs.OnHit(groupVersion)
+	return cachedVal.resourceList, cachedVal.err
+}
+
+// fetchResourcesLocked performs a synchronous live fetch of groupVersion and
+// replaces its cache entry. Callers must hold d.lock.
+func (d *memCacheClient) fetchResourcesLocked(groupVersion string) (*metav1.APIResourceList, error) {
+	d.obs.OnMiss(groupVersion)
+	start := time.Now()
+	r, err := d.delegate.ServerResourcesForGroupVersion(groupVersion)
+	d.obs.OnRefresh(groupVersion, time.Since(start), err)
+	if err != nil {
+		d.obs.OnError(groupVersion, err, isTransientError(err))
+	}
+	d.groupToServerResources[groupVersion] = &cacheEntry{r, err, d.opts.Clock.Now()}
+	return r, err
+}
+
+// maybeStartResourceRefreshLocked kicks off a single-flight goroutine to
+// refetch groupVersion and swap its cache entry once it lands. Callers must
+// hold d.lock; it is released and re-acquired internally around the fetch.
+func (d *memCacheClient) maybeStartResourceRefreshLocked(groupVersion string) {
+	if d.resourcesInFlight[groupVersion] {
+		return
+	}
+	d.resourcesInFlight[groupVersion] = true
+	d.obs.OnMiss(groupVersion)
+	go func() {
+		start := time.Now()
+		r, err := d.delegate.ServerResourcesForGroupVersion(groupVersion)
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		d.resourcesInFlight[groupVersion] = false
+		d.obs.OnRefresh(groupVersion, time.Since(start), err)
+		if err != nil {
+			d.obs.OnError(groupVersion, err, isTransientError(err))
+		}
+		d.groupToServerResources[groupVersion] = &cacheEntry{r, err, d.opts.Clock.Now()}
+	}()
+}
+
+// ServerGroups returns the supported groups, with their versions.
+func (d *memCacheClient) ServerGroups() (*metav1.APIGroupList, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if !d.cacheValid {
+		if err := d.refreshLocked(); err != nil {
+			return nil, err
+		}
+		return d.groupList, nil
+	}
+	if d.opts.TTL != 0 && d.pastLocked(d.groupsFetchedAt, d.opts.TTL) {
+		if d.opts.RefreshMode == StaleWhileRevalidate && !d.pastMaxAgeLocked(d.groupsFetchedAt) {
+			d.maybeStartGroupsRefreshLocked()
+			d.obs.OnHit(groupListKey)
+			return d.groupList, nil
+		}
+		if err := d.refreshLocked(); err != nil {
+			return nil, err
+		}
+		return d.groupList, nil
+	}
+	d.obs.OnHit(groupListKey)
+	return d.groupList, nil
+}
+
+// refreshLocked performs a synchronous live ServerGroups() fetch, replaces
+// the cached group list and discards any per-groupVersion resource cache
+// (it was populated against the old group list). When the delegate answers
+// the aggregated discovery request (see refreshAggregatedLocked), that one
+// response replaces both the group list and the per-groupVersion cache
+// instead. Callers must hold d.lock.
+func (d *memCacheClient) refreshLocked() error {
+	if handled, err := d.refreshAggregatedLocked(); handled {
+		if err != nil {
+			d.obs.OnError(groupListKey, err, isTransientError(err))
+		}
+		return err
+	}
+
+	d.obs.OnMiss(groupListKey)
+	start := time.Now()
+	liveGroups, err := d.delegate.ServerGroups()
+	d.obs.OnRefresh(groupListKey, time.Since(start), err)
+	if err != nil {
+		d.obs.OnError(groupListKey, err, isTransientError(err))
+		return err
+	}
+	d.groupList = liveGroups
+	d.groupToServerResources = map[string]*cacheEntry{}
+	d.cacheValid = true
+	d.groupsFetchedAt = d.opts.Clock.Now()
+	return nil
+}
+
+// maybeStartGroupsRefreshLocked kicks off a single-flight goroutine to
+// refetch the group list and swap it in once it lands. It deliberately
+// leaves the per-groupVersion resource cache alone: those entries are
+// refreshed independently via their own TTL. Callers must hold d.lock.
+func (d *memCacheClient) maybeStartGroupsRefreshLocked() {
+	if d.groupsInFlight {
+		return
+	}
+	d.groupsInFlight = true
+	d.obs.OnMiss(groupListKey)
+	go func() {
+		start := time.Now()
+		liveGroups, err := d.delegate.ServerGroups()
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		d.groupsInFlight = false
+		d.obs.OnRefresh(groupListKey, time.Since(start), err)
+		if err != nil {
+			d.obs.OnError(groupListKey, err, isTransientError(err))
+			return
+		}
+		d.groupList = liveGroups
+		d.groupsFetchedAt = d.opts.Clock.Now()
+	}()
+}
+
+func (d *memCacheClient) pastLocked(fetchedAt time.Time, after time.Duration) bool {
+	return d.opts.Clock.Now().Sub(fetchedAt) >= after
+}
+
+func (d *memCacheClient) pastMaxAgeLocked(fetchedAt time.Time) bool {
+	if d.opts.MaxAge == 0 {
+		return false
+	}
+	return d.pastLocked(fetchedAt, d.opts.MaxAge)
+}
+
+func (d *memCacheClient) RESTClient() restclient.Interface {
+	return d.delegate.RESTClient()
+}
+
+func (d *memCacheClient) ServerPreferredResources() ([]*metav1.APIResourceList, error) {
+	return discovery.ServerPreferredResources(d)
+}
+
+func (d *memCacheClient) ServerPreferredNamespacedResources() ([]*metav1.APIResourceList, error) {
+	return discovery.ServerPreferredNamespacedResources(d)
+}
+
+func (d *memCacheClient) ServerVersion() (*metav1.APIVersions, error) {
+	return d.delegate.ServerVersion()
+}
+
+func (d *memCacheClient) OpenAPISchema() (*metav1.APIGroupList, error) {
+	return nil, fmt.Errorf("OpenAPISchema is not cached")
+}
+
+func (d *memCacheClient) OpenAPIV3() openapi.Client {
+	return d.openapiClient
+}
+
+func (d *memCacheClient) Fresh() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.cacheValid
+}
+
+// Invalidate enforces that no cached data that is older than this call is
+// used.
+//
+// When the delegate serves aggregated discovery, the content digest from the
+// last fetch is deliberately kept: the next refresh still issues a full
+// request, but only pays the cost of re-converting the document if its
+// content actually changed.
+func (d *memCacheClient) Invalidate() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.cacheValid = false
+	d.groupToServerResources = map[string]*cacheEntry{}
+	d.groupList = nil
+	d.openapiClient = cached.NewClient(d.delegate.OpenAPIV3())
+	d.obs.OnInvalidate()
+	d.obs.OnOpenAPIRebuild()
+}
+
+// isTransientError reports whether err is the kind of retryable failure that
+// should not be cached indefinitely: anything the apiserver flagged as
+// temporarily unavailable, overloaded, or timed out. Anything else (e.g.
+// NotFound, Forbidden) is treated as a permanent condition and is only
+// retried after an explicit Invalidate() or, if configured, after
+// NegativeTTL elapses.
+func isTransientError(err error) bool {
+	return errorsutil.IsTooManyRequests(err) || errorsutil.IsServiceUnavailable(err) || errorsutil.IsServerTimeout(err) || errorsutil.IsTimeout(err)
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	apidiscoveryv2 "k8s.io/api/apidiscovery/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// aggregatedDiscoveryAccept is the content type apidiscovery.k8s.io/v2
+// aggregated discovery is served as: a single document covering every group,
+// version and resource, in place of one ServerGroups() call plus one
+// ServerResourcesForGroupVersion() call per advertised group version.
+const aggregatedDiscoveryAccept = "application/json;g=apidiscovery.k8s.io;v=v2;as=APIGroupDiscoveryList"
+
+// convertAggregatedDiscovery flattens an APIGroupDiscoveryList into the
+// metav1.APIGroupList plus per-groupVersion APIResourceList shape the rest
+// of memCacheClient already works with.
+func convertAggregatedDiscovery(in *apidiscoveryv2.APIGroupDiscoveryList) (*metav1.APIGroupList, map[string]*metav1.APIResourceList) {
+	groupList := &metav1.APIGroupList{}
+	resources := map[string]*metav1.APIResourceList{}
+
+	for _, g := range in.Items {
+		group := metav1.APIGroup{Name: g.Name}
+		for _, v := range g.Versions {
+			groupVersion := v.Version
+			if g.Name != "" {
+				groupVersion = g.Name + "/" + v.Version
+			}
+			group.Versions = append(group.Versions, metav1.GroupVersionForDiscovery{
+				GroupVersion: groupVersion,
+				Version:      v.Version,
+			})
+
+			resourceList := &metav1.APIResourceList{GroupVersion: groupVersion}
+			for _, r := range v.Resources {
+				// ResponseKind is optional in the aggregated discovery API;
+				// an apiserver that omits it for a resource must not crash
+				// the refresh, it just means an empty Kind.
+				var kind string
+				if r.ResponseKind != nil {
+					kind = r.ResponseKind.Kind
+				}
+				resourceList.APIResources = append(resourceList.APIResources, metav1.APIResource{
+					Name:         r.Resource,
+					SingularName: r.SingularResource,
+					Namespaced:   r.Scope == apidiscoveryv2.ScopeNamespace,
+					Kind:         kind,
+					ShortNames:   r.ShortNames,
+				})
+			}
+			resources[groupVersion] = resourceList
+		}
+		if len(group.Versions) > 0 {
+			group.PreferredVersion = group.Versions[0]
+		}
+		groupList.Groups = append(groupList.Groups, group)
+	}
+
+	return groupList, resources
+}
+
+// refreshAggregatedLocked tries to (re)populate the cache in a single round
+// trip against the real aggregated discovery endpoint, using the delegate's
+// own RESTClient() -- every discovery.DiscoveryInterface exposes one, so
+// this path is reachable against any real apiserver that serves the
+// aggregated content type, not just a purpose-built test double.
+//
+// handled is false when the delegate has no RESTClient() at all (a minimal
+// test fake, most commonly), or when the response doesn't decode as an
+// APIGroupDiscoveryList: an apiserver that doesn't support the aggregated
+// content type answers "/apis" with the legacy metav1.APIGroupList shape
+// instead, which the kind check below rejects. Either way the caller falls
+// back to the legacy per-groupVersion calls. Callers must hold d.lock.
+//
+// This always issues a full request rather than a conditional one:
+// discovery.DiscoveryInterface's RESTClient() doesn't expose response
+// headers, so there is no apiserver-issued ETag available to send back as
+// If-None-Match. What it does save is the cost of re-converting an
+// unchanged document -- see the lastDigest comparison below.
+func (d *memCacheClient) refreshAggregatedLocked() (handled bool, err error) {
+	restClient := d.delegate.RESTClient()
+	if restClient == nil {
+		return false, nil
+	}
+
+	result := restClient.Get().AbsPath("/apis").SetHeader("Accept", aggregatedDiscoveryAccept).Do(context.Background())
+
+	var statusCode int
+	result.StatusCode(&statusCode)
+	raw, rawErr := result.Raw()
+	if rawErr != nil || statusCode != http.StatusOK {
+		return false, nil
+	}
+
+	var doc apidiscoveryv2.APIGroupDiscoveryList
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return false, nil
+	}
+	// A legacy APIGroupList body decodes without error (its fields are
+	// simply ignored), so its kind is what tells it apart.
+	if doc.Kind != "" && doc.Kind != "APIGroupDiscoveryList" {
+		return false, nil
+	}
+
+	digest := contentDigest(raw)
+	if digest == d.lastDigest && d.aggregatedGroups != nil {
+		d.applyAggregatedLocked(d.aggregatedGroups, d.aggregatedResources)
+		return true, nil
+	}
+
+	groupList, resources := convertAggregatedDiscovery(&doc)
+	d.lastDigest = digest
+	d.applyAggregatedLocked(groupList, resources)
+	return true, nil
+}
+
+// applyAggregatedLocked installs groupList/resources as the current cache
+// contents. Callers must hold d.lock.
+func (d *memCacheClient) applyAggregatedLocked(groupList *metav1.APIGroupList, resources map[string]*metav1.APIResourceList) {
+	d.aggregatedGroups = groupList
+	d.aggregatedResources = resources
+	d.groupList = groupList
+	d.groupToServerResources = d.aggregatedResourcesLocked()
+	d.cacheValid = true
+	d.groupsFetchedAt = d.opts.Clock.Now()
+}
+
+// aggregatedResourcesLocked converts the last aggregated fetch into fresh
+// cacheEntry values timestamped now, so TTL/NegativeTTL bookkeeping on
+// individual groupVersions behaves the same regardless of which discovery
+// path populated them. Callers must hold d.lock.
+func (d *memCacheClient) aggregatedResourcesLocked() map[string]*cacheEntry {
+	out := make(map[string]*cacheEntry, len(d.aggregatedResources))
+	now := d.opts.Clock.Now()
+	for gv, list := range d.aggregatedResources {
+		out[gv] = &cacheEntry{resourceList: list, fetchedAt: now}
+	}
+	return out
+}
+
+func contentDigest(raw []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(raw))
+}
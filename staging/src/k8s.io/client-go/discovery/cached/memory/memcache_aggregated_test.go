@@ -0,0 +1,176 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	apidiscoveryv2 "k8s.io/api/apidiscovery/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+// aggregatedFakeServer is a real HTTP server answering "/apis" with an
+// apidiscovery.k8s.io/v2 APIGroupDiscoveryList, so tests exercise the exact
+// content negotiation refreshAggregatedLocked does against a live apiserver,
+// rather than a hand-rolled Go interface.
+type aggregatedFakeServer struct {
+	*httptest.Server
+
+	lock  sync.Mutex
+	calls int
+	list  *apidiscoveryv2.APIGroupDiscoveryList
+}
+
+func newAggregatedFakeServer() *aggregatedFakeServer {
+	s := &aggregatedFakeServer{
+		list: &apidiscoveryv2.APIGroupDiscoveryList{
+			Items: []apidiscoveryv2.APIGroupDiscovery{{
+				ObjectMeta: metav1.ObjectMeta{Name: "astronomy"},
+				Versions: []apidiscoveryv2.APIVersionDiscovery{{
+					Version: "v8beta1",
+					Resources: []apidiscoveryv2.APIResourceDiscovery{{
+						Resource:         "dwarfplanets",
+						SingularResource: "dwarfplanet",
+						Scope:            apidiscoveryv2.ScopeNamespace,
+						ResponseKind:     &metav1.GroupVersionKind{Kind: "DwarfPlanet"},
+						ShortNames:       []string{"dp"},
+					}},
+				}},
+			}},
+		},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *aggregatedFakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	s.calls++
+	s.lock.Unlock()
+
+	if r.URL.Path != "/apis" {
+		http.NotFound(w, r)
+		return
+	}
+	if accept := r.Header.Get("Accept"); accept != aggregatedDiscoveryAccept {
+		http.Error(w, "unexpected Accept header: "+accept, http.StatusNotAcceptable)
+		return
+	}
+
+	s.lock.Lock()
+	raw, err := json.Marshal(s.list)
+	s.lock.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(raw)
+}
+
+func (s *aggregatedFakeServer) Calls() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.calls
+}
+
+func (s *aggregatedFakeServer) setResource(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.list.Items[0].Versions[0].Resources[0].Resource = name
+}
+
+func newAggregatedClient(s *aggregatedFakeServer) discovery.CachedDiscoveryInterface {
+	return NewMemCacheClient(discovery.NewDiscoveryClientForConfigOrDie(&rest.Config{Host: s.URL}))
+}
+
+func TestAggregatedDiscoverySingleRoundTrip(t *testing.T) {
+	s := newAggregatedFakeServer()
+	defer s.Close()
+	c := newAggregatedClient(s)
+
+	if _, err := c.ServerGroups(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.ServerResourcesForGroupVersion("astronomy/v8beta1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Calls() != 1 {
+		t.Errorf("expected exactly one aggregated round trip, got %d", s.Calls())
+	}
+}
+
+// TestAggregatedDiscoveryUnchangedContentSkipsReconversion asserts that a
+// refresh against an apiserver whose aggregated document hasn't changed
+// still issues a full request (there's no apiserver ETag available to send
+// a conditional one against, see refreshAggregatedLocked), but reuses the
+// previously converted cache contents by content digest instead of paying
+// to re-convert an identical document.
+func TestAggregatedDiscoveryUnchangedContentSkipsReconversion(t *testing.T) {
+	s := newAggregatedFakeServer()
+	defer s.Close()
+	c := newAggregatedClient(s)
+
+	r, err := c.ServerResourcesForGroupVersion("astronomy/v8beta1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(r.APIResources) != 1 || r.APIResources[0].Name != "dwarfplanets" {
+		t.Fatalf("unexpected initial resource list: %#v", r)
+	}
+
+	c.Invalidate()
+	r2, err := c.ServerResourcesForGroupVersion("astronomy/v8beta1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Calls() != 2 {
+		t.Fatalf("expected the refresh to hit the server again despite unchanged content, got %d calls", s.Calls())
+	}
+	if reflect.ValueOf(r).Pointer() != reflect.ValueOf(r2).Pointer() {
+		t.Errorf("expected the unchanged document to reuse the previously converted resource list")
+	}
+}
+
+func TestAggregatedDiscoveryRefreshesOnContentChange(t *testing.T) {
+	s := newAggregatedFakeServer()
+	defer s.Close()
+	c := newAggregatedClient(s)
+
+	if _, err := c.ServerResourcesForGroupVersion("astronomy/v8beta1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.setResource("stars")
+	c.Invalidate()
+
+	r, err := c.ServerResourcesForGroupVersion("astronomy/v8beta1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(r.APIResources) != 1 || r.APIResources[0].Name != "stars" {
+		t.Errorf("expected the cache to pick up the changed document, got %#v", r)
+	}
+}
@@ -344,7 +344,8 @@ func TestPartialRetryableFailure(t *testing.T) {
 		},
 	}
 
-	c := NewMemCacheClient(fake)
+	obs := &recordingObserver{}
+	c := NewMemCacheClientWithObserver(fake, obs)
 	if c.Fresh() {
 		t.Errorf("Expected not fresh.")
 	}
@@ -396,6 +397,33 @@ func TestPartialRetryableFailure(t *testing.T) {
 	if e, a := fake.resourceMap["astronomy/v8beta1"].list, r; !reflect.DeepEqual(e, a) {
 		t.Errorf("Expected %#v, got %#v", e, a)
 	}
+
+	// The final call is a pure cache hit: the cached astronomy/v8beta1 entry
+	// is a cached success, so mutating the fake's error after the fact (to
+	// prove a permanent error wouldn't be retried) never surfaces as a
+	// fetch -- it's never observed at all.
+	want := []string{
+		"openapi-rebuild", // construction builds the OpenAPI cache
+		"miss:",           // first ServerResourcesForGroupVersion() call fetches the group list
+		"refresh:",
+		"miss:astronomy2/v8beta1",
+		"refresh:astronomy2/v8beta1",
+		"miss:astronomy/v8beta1",
+		"refresh:astronomy/v8beta1",
+		"error:retryable:astronomy/v8beta1",
+		"miss:astronomy/v8beta1", // retryable error always retried, group list already cached
+		"refresh:astronomy/v8beta1",
+		"hit:astronomy/v8beta1", // cached success served with no further fetch
+	}
+	events := obs.Events()
+	if len(events) != len(want) {
+		t.Fatalf("unexpected event count.\n got: %v\nwant: %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d: got %q, want %q\nfull got: %v", i, events[i], want[i], events)
+		}
+	}
 }
 
 // Tests that schema instances returned by openapi cached and returned after
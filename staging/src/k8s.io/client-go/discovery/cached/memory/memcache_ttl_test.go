@@ -0,0 +1,167 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// countingFakeDiscovery wraps a fakeDiscovery and counts live
+// ServerResourcesForGroupVersion calls, so tests can assert exactly how many
+// times the delegate was actually hit.
+type countingFakeDiscovery struct {
+	*fakeDiscovery
+	resourceCalls int64
+}
+
+func (c *countingFakeDiscovery) ServerResourcesForGroupVersion(groupVersion string) (*metav1.APIResourceList, error) {
+	atomic.AddInt64(&c.resourceCalls, 1)
+	return c.fakeDiscovery.ServerResourcesForGroupVersion(groupVersion)
+}
+
+func newAstronomyFake() *countingFakeDiscovery {
+	return &countingFakeDiscovery{
+		fakeDiscovery: &fakeDiscovery{
+			groupList: &metav1.APIGroupList{
+				Groups: []metav1.APIGroup{{
+					Name: "astronomy",
+					Versions: []metav1.GroupVersionForDiscovery{{
+						GroupVersion: "astronomy/v8beta1",
+						Version:      "v8beta1",
+					}},
+				}},
+			},
+			resourceMap: map[string]*resourceMapEntry{
+				"astronomy/v8beta1": {
+					list: &metav1.APIResourceList{
+						GroupVersion: "astronomy/v8beta1",
+						APIResources: []metav1.APIResource{{
+							Name:         "dwarfplanets",
+							SingularName: "dwarfplanet",
+							Namespaced:   true,
+							Kind:         "DwarfPlanet",
+							ShortNames:   []string{"dp"},
+						}},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestTTLCachedBeforeExpiry(t *testing.T) {
+	fake := newAstronomyFake()
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+
+	c := NewMemCacheClientWithOptions(fake, Options{TTL: time.Minute, Clock: fakeClock})
+	if _, err := c.ServerResourcesForGroupVersion("astronomy/v8beta1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fakeClock.Step(30 * time.Second)
+	if _, err := c.ServerResourcesForGroupVersion("astronomy/v8beta1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := atomic.LoadInt64(&fake.resourceCalls); got != 1 {
+		t.Errorf("expected 1 live call before TTL expiry, got %d", got)
+	}
+}
+
+func TestTTLBlockingRefreshAfterHardExpiry(t *testing.T) {
+	fake := newAstronomyFake()
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+
+	c := NewMemCacheClientWithOptions(fake, Options{
+		TTL:         time.Minute,
+		MaxAge:      2 * time.Minute,
+		RefreshMode: StaleWhileRevalidate,
+		Clock:       fakeClock,
+	})
+	if _, err := c.ServerResourcesForGroupVersion("astronomy/v8beta1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fakeClock.Step(3 * time.Minute)
+	if _, err := c.ServerResourcesForGroupVersion("astronomy/v8beta1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := atomic.LoadInt64(&fake.resourceCalls); got != 2 {
+		t.Errorf("expected a synchronous refetch once past MaxAge, got %d live calls", got)
+	}
+}
+
+func TestTTLStaleWhileRevalidateAsyncReplacement(t *testing.T) {
+	fake := newAstronomyFake()
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+
+	c := NewMemCacheClientWithOptions(fake, Options{
+		TTL:         time.Minute,
+		MaxAge:      time.Hour,
+		RefreshMode: StaleWhileRevalidate,
+		Clock:       fakeClock,
+	})
+	r, err := c.ServerResourcesForGroupVersion("astronomy/v8beta1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.APIResources[0].Name != "dwarfplanets" {
+		t.Fatalf("unexpected initial resource list: %#v", r)
+	}
+
+	fake.lock.Lock()
+	fake.resourceMap["astronomy/v8beta1"] = &resourceMapEntry{
+		list: &metav1.APIResourceList{
+			GroupVersion: "astronomy/v8beta1",
+			APIResources: []metav1.APIResource{{
+				Name:         "stars",
+				SingularName: "star",
+				Namespaced:   true,
+				Kind:         "Star",
+				ShortNames:   []string{"s"},
+			}},
+		},
+	}
+	fake.lock.Unlock()
+
+	fakeClock.Step(90 * time.Second)
+	r, err = c.ServerResourcesForGroupVersion("astronomy/v8beta1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.APIResources[0].Name != "dwarfplanets" {
+		t.Errorf("expected stale cached value returned immediately, got %#v", r)
+	}
+
+	deadline := time.After(2 * time.Second)
+	for {
+		r, err = c.ServerResourcesForGroupVersion("astronomy/v8beta1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if r.APIResources[0].Name == "stars" {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("timed out waiting for background refresh to replace the stale entry")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
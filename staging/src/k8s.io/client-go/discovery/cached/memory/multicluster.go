@@ -0,0 +1,206 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+)
+
+// MultiClusterError collects the per-cluster errors from a fanned-out
+// MultiClusterCachedDiscoveryInterface call. A failing cluster never poisons
+// the results gathered from the others: callers get both the partial results
+// and this error, and can decide whether a partial result is acceptable.
+type MultiClusterError struct {
+	Errors map[string]error
+}
+
+func (e *MultiClusterError) Error() string {
+	clusters := make([]string, 0, len(e.Errors))
+	for cluster := range e.Errors {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+
+	msg := fmt.Sprintf("discovery failed for %d cluster(s):", len(e.Errors))
+	for _, cluster := range clusters {
+		msg += fmt.Sprintf(" %s: %v;", cluster, e.Errors[cluster])
+	}
+	return msg
+}
+
+// MultiClusterCachedDiscoveryInterface fans a cached discovery client out
+// across a fixed set of named clusters, for callers (e.g. multi-cluster
+// controllers) that otherwise have to keep their own map of per-cluster
+// discovery.CachedDiscoveryInterface values and hand-roll invalidation and
+// error aggregation.
+type MultiClusterCachedDiscoveryInterface interface {
+	// For returns the cached discovery client for clusterName, or nil if
+	// clusterName was not part of the map passed to
+	// NewMultiClusterMemCacheClient.
+	For(clusterName string) discovery.CachedDiscoveryInterface
+	// Clusters returns the configured cluster names in sorted order.
+	Clusters() []string
+	// ServerGroupsAll fans ServerGroups() out to every cluster concurrently.
+	// A per-cluster failure is reported via the returned *MultiClusterError
+	// without affecting the groups collected from the other clusters.
+	ServerGroupsAll() (map[string]*metav1.APIGroupList, error)
+	// ServerResourcesForGroupVersionAll fans
+	// ServerResourcesForGroupVersion(groupVersion) out to every cluster
+	// concurrently, with the same partial-failure semantics as
+	// ServerGroupsAll.
+	ServerResourcesForGroupVersionAll(groupVersion string) (map[string]*metav1.APIResourceList, error)
+	// Invalidate invalidates every cluster's cache.
+	Invalidate()
+	// InvalidateCluster invalidates a single cluster's cache. It is a no-op
+	// if clusterName is not configured.
+	InvalidateCluster(clusterName string)
+	// Fresh reports true iff every cluster's cache is fresh.
+	Fresh() bool
+}
+
+type multiClusterMemCacheClient struct {
+	clients map[string]discovery.CachedDiscoveryInterface
+}
+
+// NewMultiClusterMemCacheClient wraps one in-memory cached discovery client
+// per entry in delegates, keyed by cluster name.
+func NewMultiClusterMemCacheClient(delegates map[string]discovery.DiscoveryInterface) MultiClusterCachedDiscoveryInterface {
+	clients := make(map[string]discovery.CachedDiscoveryInterface, len(delegates))
+	for name, delegate := range delegates {
+		clients[name] = NewMemCacheClient(delegate)
+	}
+	return &multiClusterMemCacheClient{clients: clients}
+}
+
+func (m *multiClusterMemCacheClient) For(clusterName string) discovery.CachedDiscoveryInterface {
+	return m.clients[clusterName]
+}
+
+func (m *multiClusterMemCacheClient) Clusters() []string {
+	clusters := make([]string, 0, len(m.clients))
+	for name := range m.clients {
+		clusters = append(clusters, name)
+	}
+	sort.Strings(clusters)
+	return clusters
+}
+
+func (m *multiClusterMemCacheClient) ServerGroupsAll() (map[string]*metav1.APIGroupList, error) {
+	type result struct {
+		cluster string
+		groups  *metav1.APIGroupList
+		err     error
+	}
+
+	results := make(chan result, len(m.clients))
+	var wg sync.WaitGroup
+	for cluster, client := range m.clients {
+		wg.Add(1)
+		go func(cluster string, client discovery.CachedDiscoveryInterface) {
+			defer wg.Done()
+			groups, err := client.ServerGroups()
+			results <- result{cluster, groups, err}
+		}(cluster, client)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	groupsByCluster := make(map[string]*metav1.APIGroupList, len(m.clients))
+	errsByCluster := map[string]error{}
+	for r := range results {
+		if r.err != nil {
+			errsByCluster[r.cluster] = r.err
+			continue
+		}
+		groupsByCluster[r.cluster] = r.groups
+	}
+	if len(errsByCluster) > 0 {
+		return groupsByCluster, &MultiClusterError{Errors: errsByCluster}
+	}
+	return groupsByCluster, nil
+}
+
+func (m *multiClusterMemCacheClient) ServerResourcesForGroupVersionAll(groupVersion string) (map[string]*metav1.APIResourceList, error) {
+	type result struct {
+		cluster   string
+		resources *metav1.APIResourceList
+		err       error
+	}
+
+	results := make(chan result, len(m.clients))
+	var wg sync.WaitGroup
+	for cluster, client := range m.clients {
+		wg.Add(1)
+		go func(cluster string, client discovery.CachedDiscoveryInterface) {
+			defer wg.Done()
+			resources, err := client.ServerResourcesForGroupVersion(groupVersion)
+			results <- result{cluster, resources, err}
+		}(cluster, client)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	resourcesByCluster := make(map[string]*metav1.APIResourceList, len(m.clients))
+	errsByCluster := map[string]error{}
+	for r := range results {
+		if r.err != nil {
+			errsByCluster[r.cluster] = r.err
+			continue
+		}
+		resourcesByCluster[r.cluster] = r.resources
+	}
+	if len(errsByCluster) > 0 {
+		return resourcesByCluster, &MultiClusterError{Errors: errsByCluster}
+	}
+	return resourcesByCluster, nil
+}
+
+func (m *multiClusterMemCacheClient) Invalidate() {
+	var wg sync.WaitGroup
+	for _, client := range m.clients {
+		wg.Add(1)
+		go func(client discovery.CachedDiscoveryInterface) {
+			defer wg.Done()
+			client.Invalidate()
+		}(client)
+	}
+	wg.Wait()
+}
+
+func (m *multiClusterMemCacheClient) InvalidateCluster(clusterName string) {
+	if client, ok := m.clients[clusterName]; ok {
+		client.Invalidate()
+	}
+}
+
+func (m *multiClusterMemCacheClient) Fresh() bool {
+	for _, client := range m.clients {
+		if !client.Fresh() {
+			return false
+		}
+	}
+	return true
+}
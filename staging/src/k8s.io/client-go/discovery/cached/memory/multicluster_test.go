@@ -0,0 +1,101 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+)
+
+func newAstronomyGroupsFake(name string) *fakeDiscovery {
+	return &fakeDiscovery{
+		groupList: &metav1.APIGroupList{
+			Groups: []metav1.APIGroup{{
+				Name: name,
+				Versions: []metav1.GroupVersionForDiscovery{{
+					GroupVersion: name + "/v1",
+					Version:      "v1",
+				}},
+			}},
+		},
+		resourceMap: map[string]*resourceMapEntry{
+			name + "/v1": {
+				list: &metav1.APIResourceList{GroupVersion: name + "/v1"},
+			},
+		},
+	}
+}
+
+func TestMultiClusterServerGroupsAllPartialFailure(t *testing.T) {
+	healthy := newAstronomyGroupsFake("astronomy")
+	failing := newAstronomyGroupsFake("astronomy2")
+	failing.groupListErr = errors.New("cluster unreachable")
+
+	m := NewMultiClusterMemCacheClient(map[string]discovery.DiscoveryInterface{
+		"healthy": healthy,
+		"failing": failing,
+	})
+
+	groups, err := m.ServerGroupsAll()
+	if err == nil {
+		t.Fatal("expected a MultiClusterError from the failing cluster")
+	}
+	mcErr, ok := err.(*MultiClusterError)
+	if !ok {
+		t.Fatalf("expected *MultiClusterError, got %T", err)
+	}
+	if _, ok := mcErr.Errors["failing"]; !ok {
+		t.Errorf("expected an error entry for the failing cluster, got %v", mcErr.Errors)
+	}
+	if _, ok := groups["healthy"]; !ok {
+		t.Errorf("expected results from the healthy cluster despite the other failing, got %v", groups)
+	}
+}
+
+func TestMultiClusterConcurrentInvalidate(t *testing.T) {
+	clients := map[string]discovery.DiscoveryInterface{
+		"a": newAstronomyGroupsFake("a"),
+		"b": newAstronomyGroupsFake("b"),
+		"c": newAstronomyGroupsFake("c"),
+	}
+	m := NewMultiClusterMemCacheClient(clients)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Invalidate()
+		}()
+	}
+	for _, cluster := range m.Clusters() {
+		wg.Add(1)
+		go func(cluster string) {
+			defer wg.Done()
+			m.InvalidateCluster(cluster)
+		}(cluster)
+	}
+	wg.Wait()
+
+	if got, want := m.Clusters(), []string{"a", "b", "c"}; len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
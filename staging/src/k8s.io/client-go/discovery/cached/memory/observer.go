@@ -0,0 +1,83 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"time"
+
+	"k8s.io/client-go/discovery"
+)
+
+// groupListKey is the groupVersion value CacheObserver methods are called
+// with when the event is about the group list as a whole rather than a
+// single groupVersion's resource list.
+const groupListKey = ""
+
+// CacheObserver receives callbacks for cache events on a memCacheClient, so
+// callers can wire up metrics or tracing without memCacheClient itself
+// depending on any particular backend. All methods must be safe to call
+// concurrently and should return quickly: they run with d.lock held.
+type CacheObserver interface {
+	// OnHit is called when a cached entry is returned without a live fetch.
+	OnHit(groupVersion string)
+	// OnMiss is called immediately before a live fetch is issued, whether
+	// because the entry was never cached, is past its TTL, or holds a
+	// retryable error. The aggregated discovery request a refresh tries
+	// first (see refreshAggregatedLocked) is not reported through OnMiss or
+	// OnRefresh.
+	OnMiss(groupVersion string)
+	// OnError is called when a live fetch returns err. retryable reports
+	// whether isTransientError classified it as transient.
+	OnError(groupVersion string, err error, retryable bool)
+	// OnRefresh is called after every live fetch (success or failure) with
+	// its wall-clock duration.
+	OnRefresh(groupVersion string, duration time.Duration, err error)
+	// OnInvalidate is called on every Invalidate() call.
+	OnInvalidate()
+	// OnOpenAPIRebuild is called whenever the OpenAPI cache is rebuilt
+	// (construction and every Invalidate()).
+	OnOpenAPIRebuild()
+}
+
+// noopObserver is the default CacheObserver: every method is a no-op, so
+// memCacheClient can call d.obs unconditionally without nil checks.
+type noopObserver struct{}
+
+func (noopObserver) OnHit(string)                           {}
+func (noopObserver) OnMiss(string)                          {}
+func (noopObserver) OnError(string, error, bool)            {}
+func (noopObserver) OnRefresh(string, time.Duration, error) {}
+func (noopObserver) OnInvalidate()                          {}
+func (noopObserver) OnOpenAPIRebuild()                      {}
+
+// NewMemCacheClientWithObserver is like NewMemCacheClient but additionally
+// reports cache hits, misses, errors and invalidations to obs, so callers
+// can plug in metrics (see PrometheusObserver) or tracing (see
+// OTelSpanObserver) without changing how the cache itself behaves.
+func NewMemCacheClientWithObserver(delegate discovery.DiscoveryInterface, obs CacheObserver) discovery.CachedDiscoveryInterface {
+	return newMemCacheClientWithOptionsAndObserver(delegate, Options{}, obs)
+}
+
+func newMemCacheClientWithOptionsAndObserver(delegate discovery.DiscoveryInterface, opts Options, obs CacheObserver) discovery.CachedDiscoveryInterface {
+	c := NewMemCacheClientWithOptions(delegate, opts).(*memCacheClient)
+	if obs == nil {
+		obs = noopObserver{}
+	}
+	c.obs = obs
+	obs.OnOpenAPIRebuild()
+	return c
+}
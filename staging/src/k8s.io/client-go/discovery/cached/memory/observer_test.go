@@ -0,0 +1,58 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"sync"
+	"time"
+)
+
+// recordingObserver records every callback it receives, in order, as a
+// simple string so tests can assert on the exact event sequence.
+type recordingObserver struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (r *recordingObserver) record(event string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recordingObserver) Events() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func (r *recordingObserver) OnHit(groupVersion string)  { r.record("hit:" + groupVersion) }
+func (r *recordingObserver) OnMiss(groupVersion string) { r.record("miss:" + groupVersion) }
+func (r *recordingObserver) OnError(groupVersion string, _ error, retryable bool) {
+	if retryable {
+		r.record("error:retryable:" + groupVersion)
+	} else {
+		r.record("error:permanent:" + groupVersion)
+	}
+}
+func (r *recordingObserver) OnRefresh(groupVersion string, _ time.Duration, _ error) {
+	r.record("refresh:" + groupVersion)
+}
+func (r *recordingObserver) OnInvalidate()     { r.record("invalidate") }
+func (r *recordingObserver) OnOpenAPIRebuild() { r.record("openapi-rebuild") }
+
+var _ CacheObserver = &recordingObserver{}
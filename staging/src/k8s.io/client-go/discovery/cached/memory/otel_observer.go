@@ -0,0 +1,118 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OTelSpanObserver is a CacheObserver that starts a span around each
+// backing live fetch (OnMiss through the matching OnRefresh, or through the
+// OnError that follows it when the fetch failed). Cache hits, invalidations
+// and OpenAPI rebuilds are each recorded as a separate span that is ended
+// right away.
+//
+// Unlike PrometheusObserver, whose methods are independent and
+// order-agnostic, OTelSpanObserver needs the OnMiss/OnRefresh(/OnError)
+// calls for the same groupVersion to correlate into one span, so it tracks
+// in-flight spans keyed by groupVersion.
+type OTelSpanObserver struct {
+	tracer trace.Tracer
+
+	lock  sync.Mutex
+	spans map[string]spanEntry
+}
+
+type spanEntry struct {
+	span  trace.Span
+	start time.Time
+}
+
+// NewOTelSpanObserver creates an OTelSpanObserver that starts spans on
+// tracer.
+func NewOTelSpanObserver(tracer trace.Tracer) *OTelSpanObserver {
+	return &OTelSpanObserver{
+		tracer: tracer,
+		spans:  map[string]spanEntry{},
+	}
+}
+
+func (o *OTelSpanObserver) OnHit(groupVersion string) {
+	_, span := o.tracer.Start(context.Background(), "discovery.cache.hit",
+		trace.WithAttributes(attribute.String("group_version", groupVersion)))
+	span.End()
+}
+
+func (o *OTelSpanObserver) OnMiss(groupVersion string) {
+	_, span := o.tracer.Start(context.Background(), "discovery.cache.refresh",
+		trace.WithAttributes(attribute.String("group_version", groupVersion)))
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.spans[groupVersion] = spanEntry{span: span, start: time.Now()}
+}
+
+func (o *OTelSpanObserver) OnError(groupVersion string, err error, retryable bool) {
+	o.lock.Lock()
+	entry, ok := o.spans[groupVersion]
+	delete(o.spans, groupVersion)
+	o.lock.Unlock()
+	if !ok {
+		return
+	}
+	entry.span.SetStatus(codes.Error, err.Error())
+	entry.span.SetAttributes(attribute.Bool("retryable", retryable))
+	entry.span.End()
+}
+
+func (o *OTelSpanObserver) OnRefresh(groupVersion string, duration time.Duration, err error) {
+	o.lock.Lock()
+	entry, ok := o.spans[groupVersion]
+	if err == nil {
+		delete(o.spans, groupVersion)
+	}
+	o.lock.Unlock()
+	if !ok {
+		return
+	}
+	entry.span.SetAttributes(attribute.Int64("duration_ms", duration.Milliseconds()))
+	if err != nil {
+		// memCacheClient calls OnError after OnRefresh for a failed fetch;
+		// OnError ends the span.
+		return
+	}
+	entry.span.SetStatus(codes.Ok, "")
+	entry.span.End()
+}
+
+func (o *OTelSpanObserver) OnInvalidate() {
+	_, span := o.tracer.Start(context.Background(), "discovery.cache.invalidate")
+	span.End()
+}
+
+func (o *OTelSpanObserver) OnOpenAPIRebuild() {
+	_, span := o.tracer.Start(context.Background(), "discovery.cache.openapi_rebuild")
+	span.End()
+}
+
+var _ CacheObserver = &OTelSpanObserver{}
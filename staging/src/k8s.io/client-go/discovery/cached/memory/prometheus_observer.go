@@ -0,0 +1,110 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memory
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusObserver is a CacheObserver that records cache hits, misses and
+// errors as counters partitioned by groupVersion and, for errors, by
+// whether the failure was classified retryable or permanent, plus a
+// histogram of live-fetch latency.
+type PrometheusObserver struct {
+	hits            *prometheus.CounterVec
+	misses          *prometheus.CounterVec
+	errors          *prometheus.CounterVec
+	invalidations   prometheus.Counter
+	openAPIRebuilds prometheus.Counter
+	refreshLatency  *prometheus.HistogramVec
+}
+
+// NewPrometheusObserver creates a PrometheusObserver and registers its
+// metrics with reg. Pass a *prometheus.Registry (or any prometheus.Registerer)
+// scoped to however the caller organizes its metrics.
+func NewPrometheusObserver(reg prometheus.Registerer) *PrometheusObserver {
+	o := &PrometheusObserver{
+		hits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "discovery_cache",
+			Name:      "hits_total",
+			Help:      "Number of discovery cache lookups served from the in-memory cache, by groupVersion.",
+		}, []string{"group_version"}),
+		misses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "discovery_cache",
+			Name:      "misses_total",
+			Help:      "Number of discovery cache lookups that required a live fetch, by groupVersion.",
+		}, []string{"group_version"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "discovery_cache",
+			Name:      "errors_total",
+			Help:      "Number of live discovery fetch errors, by groupVersion and whether the error was retryable.",
+		}, []string{"group_version", "retryable"}),
+		invalidations: prometheus.NewCounter(prometheus.CounterOpts{
+			Subsystem: "discovery_cache",
+			Name:      "invalidations_total",
+			Help:      "Number of Invalidate() calls.",
+		}),
+		openAPIRebuilds: prometheus.NewCounter(prometheus.CounterOpts{
+			Subsystem: "discovery_cache",
+			Name:      "openapi_rebuilds_total",
+			Help:      "Number of times the cached OpenAPI client was rebuilt.",
+		}),
+		refreshLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Subsystem: "discovery_cache",
+			Name:      "refresh_duration_seconds",
+			Help:      "Latency of live discovery fetches, by groupVersion.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"group_version"}),
+	}
+	reg.MustRegister(o.hits, o.misses, o.errors, o.invalidations, o.openAPIRebuilds, o.refreshLatency)
+	return o
+}
+
+func (o *PrometheusObserver) OnHit(groupVersion string) {
+	o.hits.WithLabelValues(groupVersion).Inc()
+}
+
+func (o *PrometheusObserver) OnMiss(groupVersion string) {
+	o.misses.WithLabelValues(groupVersion).Inc()
+}
+
+func (o *PrometheusObserver) OnError(groupVersion string, _ error, retryable bool) {
+	o.errors.WithLabelValues(groupVersion, boolLabel(retryable)).Inc()
+}
+
+func (o *PrometheusObserver) OnRefresh(groupVersion string, duration time.Duration, _ error) {
+	o.refreshLatency.WithLabelValues(groupVersion).Observe(duration.Seconds())
+}
+
+func (o *PrometheusObserver) OnInvalidate() {
+	o.invalidations.Inc()
+}
+
+func (o *PrometheusObserver) OnOpenAPIRebuild() {
+	o.openAPIRebuilds.Inc()
+}
+
+func boolLabel(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+var _ CacheObserver = &PrometheusObserver{}